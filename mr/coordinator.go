@@ -1,6 +1,7 @@
 package mr
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -18,6 +19,9 @@ const (
 	MAP         = 0
 	REDUCE      = 1
 	NONE 		= 2
+
+	// a task reported as failed this many times fails the whole job
+	MAX_FAILURES = 3
 )
 
 type Task struct {
@@ -25,6 +29,7 @@ type Task struct {
 	filename  string
 	state     int
 	timestamp time.Time
+	failures  int
 }
 
 type Coordinator struct {
@@ -33,6 +38,10 @@ type Coordinator struct {
 	reduceRemain  int
 	mTasks        []*Task
 	rTasks        []*Task
+	network       string
+	address       string
+	listener      net.Listener
+	failure       string // reason the job failed, empty while it is healthy
 }
 
 // Your code here -- RPC handlers for the worker to call.
@@ -59,6 +68,11 @@ func wait(task *Task) {
 func (c *Coordinator) HandleQuery(args *QueryArgs, reply *QueryReply) error {
 	reply.Kind = "none"
 	c.mu.Lock()
+	if c.failure != "" {
+		// job has failed, hand out no more work
+		c.mu.Unlock()
+		return nil
+	}
 	if c.mapRemain != 0 {
 		// look for a map task
 		for i, task := range c.mTasks {
@@ -107,7 +121,27 @@ func (c *Coordinator) HandleResponse(args *ResponseArgs, reply *ResponseReply) e
 		task = c.rTasks[args.Index]
 	}
 
-	if now.Before(task.timestamp.Add(10 * time.Second)) {
+	if args.Err != "" {
+		// the worker gave up on the task, retry it unless it keeps failing
+		task.lock.Lock()
+		if task.state != IN_PROGRESS || !now.Before(task.timestamp.Add(10*time.Second)) {
+			// stale report from a timed-out worker, the task was already reset or finished
+			task.lock.Unlock()
+			return nil
+		}
+		task.state = IDLE
+		task.failures++
+		failures := task.failures
+		task.lock.Unlock()
+		fmt.Fprintf(os.Stderr, "%s coordinator: %s task %d failed (%d/%d): %s\n", time.Now().String(), args.Kind, args.Index, failures, MAX_FAILURES, args.Err)
+		if failures >= MAX_FAILURES {
+			c.mu.Lock()
+			if c.failure == "" {
+				c.failure = fmt.Sprintf("%s task %d failed %d times: %s", args.Kind, args.Index, failures, args.Err)
+			}
+			c.mu.Unlock()
+		}
+	} else if now.Before(task.timestamp.Add(10 * time.Second)) {
 		task.lock.Lock()
 		task.state = COMPLETED
 		task.lock.Unlock()
@@ -131,16 +165,30 @@ func (c *Coordinator) HandleResponse(args *ResponseArgs, reply *ResponseReply) e
 	start a thread that listens for RPCs from worker.go
 */
 func (c *Coordinator) server() {
-	rpc.Register(c)
-	rpc.HandleHTTP()
-	sockname := coordinatorSock()
-	os.Remove(sockname)
+	// use a private RPC server so that several coordinators can live in one process
+	server := rpc.NewServer()
+	server.Register(c)
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
+	if c.network == "unix" {
+		os.Remove(c.address)
+	}
 	// listening to the socket
-	l, e := net.Listen("unix", sockname)
+	l, e := net.Listen(c.network, c.address)
 	if e != nil {
 		log.Fatal("listen error:", e)
 	}
-	go http.Serve(l, nil)
+	c.listener = l
+	go http.Serve(l, mux)
+}
+
+/*
+	returns the address the coordinator is listening on, e.g. the
+	actual host:port when bound to "tcp" ":0". workers reach it
+	through WithCoordinatorAddr.
+*/
+func (c *Coordinator) Addr() string {
+	return c.listener.Addr().String()
 }
 
 /*
@@ -150,19 +198,50 @@ func (c *Coordinator) server() {
 func (c *Coordinator) Done() bool {
 	ret := false
 	c.mu.Lock()
-	if c.reduceRemain == 0 {
+	if c.reduceRemain == 0 || c.failure != "" {
 		ret = true
 	}
 	c.mu.Unlock()
 	return ret
 }
 
+/*
+	reports why the job failed once Done() returns true,
+	or nil if it completed successfully.
+*/
+func (c *Coordinator) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failure == "" {
+		return nil
+	}
+	return errors.New(c.failure)
+}
+
+type CoordinatorOption func(*Coordinator)
+
+/*
+	listen on the given network and address instead of the
+	unix socket from coordinatorSock(), e.g. "tcp" and ":0".
+*/
+func WithListenAddr(network string, address string) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.network = network
+		c.address = address
+	}
+}
+
 /*
 	create a new coordinator.
 	main/mrcoordinator.go calls this function.
 */
-func MakeCoordinator(files []string, nReduce int) *Coordinator {
+func MakeCoordinator(files []string, nReduce int, opts ...CoordinatorOption) *Coordinator {
 	coordinator := Coordinator{}
+	coordinator.network = "unix"
+	coordinator.address = coordinatorSock()
+	for _, opt := range opts {
+		opt(&coordinator)
+	}
 	coordinator.mTasks = make([]*Task, len(files))
 	coordinator.rTasks = make([]*Task, nReduce)
 	coordinator.mu = sync.Mutex{}
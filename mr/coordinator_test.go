@@ -0,0 +1,140 @@
+package mr
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// start a coordinator on an ephemeral local TCP port, so tests
+// never touch the shared coordinatorSock() path.
+func makeTestCoordinator(t *testing.T, files []string, nReduce int) *Coordinator {
+	t.Helper()
+	c := MakeCoordinator(files, nReduce, WithListenAddr("tcp", "127.0.0.1:0"))
+	t.Cleanup(func() { c.listener.Close() })
+	return c
+}
+
+func TestCoordinatorAddr(t *testing.T) {
+	c := makeTestCoordinator(t, []string{}, 1)
+
+	host, port, err := net.SplitHostPort(c.Addr())
+	if err != nil {
+		t.Fatalf("Addr() = %q is not host:port: %v", c.Addr(), err)
+	}
+	if host == "" || port == "0" {
+		t.Fatalf("Addr() = %q, want a bound host and port", c.Addr())
+	}
+
+	reply := QueryReply{}
+	if !call("tcp", c.Addr(), "Coordinator.HandleQuery", &QueryArgs{}, &reply) {
+		t.Fatalf("can not call coordinator at %v", c.Addr())
+	}
+	if reply.Kind != "reduce" {
+		t.Fatalf("reply.Kind = %q, want reduce", reply.Kind)
+	}
+}
+
+// counts occurrences, but reports a negative count for "bad".
+func negativeReduce(key string, values []string) string {
+	if key == "bad" {
+		return "-1"
+	}
+	return strconv.Itoa(len(values))
+}
+
+func nonNegative(key string, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("negative count")
+	}
+	return nil
+}
+
+func TestJobFailsWhenVerifierRejects(t *testing.T) {
+	inTempDir(t)
+	writeInput(t, "input.txt", "good bad good")
+	c := makeTestCoordinator(t, []string{"input.txt"}, 1)
+
+	reduces := 0
+	for i := 0; i < 10 && !c.Done(); i++ {
+		reply := QueryReply{}
+		c.HandleQuery(&QueryArgs{}, &reply)
+		args := ResponseArgs{Kind: reply.Kind, Index: reply.Index}
+		switch reply.Kind {
+		case "map":
+			if !executeMap(wordsMap, nil, reply.File, reply.NReduce, reply.Index) {
+				t.Fatal("map task failed")
+			}
+		case "reduce":
+			reduces++
+			if err := executeReduce(negativeReduce, nonNegative, reply.Split, reply.Index); err != nil {
+				args.Err = err.Error()
+			}
+		default:
+			t.Fatalf("unexpected task kind %q", reply.Kind)
+		}
+		c.HandleResponse(&args, &ResponseReply{})
+	}
+
+	if !c.Done() {
+		t.Fatal("job did not finish")
+	}
+	if reduces != MAX_FAILURES {
+		t.Fatalf("reduce ran %d times, want %d", reduces, MAX_FAILURES)
+	}
+	err := c.Err()
+	if err == nil || !strings.Contains(err.Error(), "bad -1") {
+		t.Fatalf("Err() = %v, want failure naming bad -1", err)
+	}
+	if _, err := os.Stat("mr-out-0"); !os.IsNotExist(err) {
+		t.Fatalf("mr-out-0 committed despite failed verification")
+	}
+
+	reply := QueryReply{}
+	c.HandleQuery(&QueryArgs{}, &reply)
+	if reply.Kind != "none" {
+		t.Fatalf("failed job handed out %q task", reply.Kind)
+	}
+}
+
+func TestStaleFailureReportIgnored(t *testing.T) {
+	c := makeTestCoordinator(t, []string{}, 2)
+
+	reply := QueryReply{}
+	c.HandleQuery(&QueryArgs{}, &reply)
+	if reply.Kind != "reduce" {
+		t.Fatalf("reply.Kind = %q, want reduce", reply.Kind)
+	}
+	c.HandleResponse(&ResponseArgs{Kind: "reduce", Index: reply.Index}, &ResponseReply{})
+
+	// a timed-out worker reports an error for the task another worker completed
+	args := ResponseArgs{Kind: "reduce", Index: reply.Index, Err: "can not read intermidiate file"}
+	c.HandleResponse(&args, &ResponseReply{})
+
+	task := c.rTasks[reply.Index]
+	task.lock.Lock()
+	state, failures := task.state, task.failures
+	task.lock.Unlock()
+	if state != COMPLETED {
+		t.Fatalf("task state = %d, want COMPLETED", state)
+	}
+	if failures != 0 {
+		t.Fatalf("task failures = %d, want 0", failures)
+	}
+	c.mu.Lock()
+	remain := c.reduceRemain
+	c.mu.Unlock()
+	if remain != 1 {
+		t.Fatalf("reduceRemain = %d, want 1", remain)
+	}
+	if c.Err() != nil {
+		t.Fatalf("Err() = %v, want nil", c.Err())
+	}
+}
@@ -26,6 +26,7 @@ type QueryReply struct {
 type ResponseArgs struct {
 	Kind  string
 	Index int
+	Err   string // non-empty if the task failed
 }
 type ResponseReply struct{}
 
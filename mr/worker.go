@@ -20,6 +20,13 @@ type KeyValue struct {
 	Value string
 }
 
+/*
+	process-unique token (PID + start time) baked into every temp file name,
+	so concurrent processes in the same directory never share a temp file.
+	final committed names do not include it.
+*/
+var procToken = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
 // for sorting by key.
 type ByKey []KeyValue
 
@@ -38,12 +45,103 @@ func ihash(key string) int {
 	return int(h.Sum32() & 0x7fffffff)
 }
 
+/*
+	Emitter collects map output into `nReduce` partitions.
+	Emit hashes the key to choose a partition, while EmitToPartition
+	and EmitToAll bypass the partitioner for joins and broadcasts.
+*/
+type Emitter struct {
+	partitions [][]KeyValue
+}
+
+/*
+	create an emitter with `nReduce` empty partitions.
+*/
+func newEmitter(nReduce int) *Emitter {
+	return &Emitter{partitions: make([][]KeyValue, nReduce)}
+}
+
+/*
+	append a record to the partition chosen by ihash(key).
+	it can not fail.
+*/
+func (e *Emitter) Emit(key, value string) {
+	index := ihash(key) % len(e.partitions)
+	e.partitions[index] = append(e.partitions[index], KeyValue{key, value})
+}
+
+/*
+	append a record to the given partition, bypassing the partitioner.
+	returns an error and writes nothing if the partition is out of range.
+*/
+func (e *Emitter) EmitToPartition(partition int, key, value string) error {
+	if partition < 0 || partition >= len(e.partitions) {
+		return fmt.Errorf("partition %d out of range [0, %d)", partition, len(e.partitions))
+	}
+	e.partitions[partition] = append(e.partitions[partition], KeyValue{key, value})
+	return nil
+}
+
+/*
+	append a copy of the record to every partition, e.g. to broadcast
+	the small side of a join. it can not fail.
+*/
+func (e *Emitter) EmitToAll(key, value string) {
+	for i := range e.partitions {
+		e.partitions[i] = append(e.partitions[i], KeyValue{key, value})
+	}
+}
+
+/*
+	optional worker settings, passed to Worker as trailing options.
+*/
+type workerConfig struct {
+	streamf  func(string, string, *Emitter)
+	verifier func(string, string) error
+	network  string
+	address  string
+}
+
+type WorkerOption func(*workerConfig)
+
+/*
+	use a map function that writes through an Emitter instead of
+	returning a slice, so it can target partitions explicitly.
+	when set it is used in place of mapf.
+*/
+func WithStreamMap(streamf func(filename string, contents string, e *Emitter)) WorkerOption {
+	return func(cfg *workerConfig) {
+		cfg.streamf = streamf
+	}
+}
+
+/*
+	dial the coordinator at the given network and address, e.g. the
+	value of Coordinator.Addr(), instead of the default unix socket.
+*/
+func WithCoordinatorAddr(network string, address string) WorkerOption {
+	return func(cfg *workerConfig) {
+		cfg.network = network
+		cfg.address = address
+	}
+}
+
+/*
+	run an assertion pass over reduce output. every record a reduce task
+	writes is checked, and an error fails the task with that record.
+*/
+func WithVerifier(verifier func(key string, value string) error) WorkerOption {
+	return func(cfg *workerConfig) {
+		cfg.verifier = verifier
+	}
+}
+
 /* 
 	worker execute map task
 	map operation on the input file given by the coordinator
 */
-func executeMap(mapf func(string, string) []KeyValue, filename string, nReduce int, index int) bool {
-	kvall := make([][]KeyValue, nReduce)
+func executeMap(mapf func(string, string) []KeyValue, streamf func(string, string, *Emitter), filename string, nReduce int, index int) bool {
+	emitter := newEmitter(nReduce)
 	file, err := os.Open(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s Worker: can not open %v\n", time.Now().String(), filename)
@@ -56,18 +154,19 @@ func executeMap(mapf func(string, string) []KeyValue, filename string, nReduce i
 	}
 	file.Close()
 
-	mapRes := mapf(filename, string(content))
-
-	// map result are mapped into `nReduce` bucket
-	for _, kv := range mapRes {
-		index := ihash(kv.Key) % nReduce
-		kvall[index] = append(kvall[index], kv)
+	if streamf != nil {
+		streamf(filename, string(content), emitter)
+	} else {
+		// map result are mapped into `nReduce` bucket
+		for _, kv := range mapf(filename, string(content)) {
+			emitter.Emit(kv.Key, kv.Value)
+		}
 	}
 
 	// write key-value to different json files
-	for i, kva := range kvall {
+	for i, kva := range emitter.partitions {
 		// implement atomical write by two-phase trick: write to a temporary file and rename it
-		oldname := fmt.Sprintf("temp_inter_%d_%d.json", index, i)
+		oldname := fmt.Sprintf("temp_inter_%s_%d_%d.json", procToken, index, i)
 		tempfile, err := os.OpenFile(oldname, os.O_RDWR|os.O_CREATE, 0755)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s Worker: map can not open temp file %v\n", time.Now().String(), oldname)
@@ -95,16 +194,16 @@ func executeMap(mapf func(string, string) []KeyValue, filename string, nReduce i
 /*
 	worker execute reduce task
 	gather all key-value stored in intermidiate files named `inter_*_index`
-	and write to a single file `mr-out-index`
+	and write to a single file `mr-out-index`.
+	returns the reason the task failed, or nil.
 */
-func executeReduce(reducef func(string, []string) string, split int, index int) bool {
+func executeReduce(reducef func(string, []string) string, verifier func(string, string) error, split int, index int) error {
 	var kva []KeyValue
 	for i := 0; i < split; i++ {
 		filename := fmt.Sprintf("inter_%d_%d.json", i, index)
 		file, err := os.Open(filename)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s Worker: can not read intermidiate file %v\n", time.Now().String(), filename)
-			return false
+			return fmt.Errorf("can not read intermidiate file %v", filename)
 		}
 
 		dec := json.NewDecoder(file)
@@ -121,15 +220,15 @@ func executeReduce(reducef func(string, []string) string, split int, index int)
 	sort.Sort(ByKey(kva))
 
 	// two-phase trick to implement atomical write
-	oldname := fmt.Sprintf("temp-mr-out-%d", index)
+	oldname := fmt.Sprintf("temp-mr-out-%s-%d", procToken, index)
 	newname := fmt.Sprintf("mr-out-%d", index)
 
 	tempfile, err := os.OpenFile(oldname, os.O_RDWR|os.O_CREATE, 0755)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s Worker: reduce can not open temp file %v\n", time.Now().String(), oldname)
-		return false
+		return fmt.Errorf("can not open temp file %v", oldname)
 	}
 	defer os.Remove(oldname)
+	defer tempfile.Close()
 
 	// reduce on values that have the same key
 	i := 0
@@ -142,16 +241,23 @@ func executeReduce(reducef func(string, []string) string, split int, index int)
 			j++
 		}
 		output := reducef(kva[i].Key, values)
+		if verifier != nil {
+			if err := verifier(kva[i].Key, output); err != nil {
+				return fmt.Errorf("output %v %v rejected by verifier: %v", kva[i].Key, output, err)
+			}
+		}
 		fmt.Fprintf(tempfile, "%v %v\n", kva[i].Key, output)
 		i = j
 	}
 
+	if err := tempfile.Close(); err != nil {
+		return fmt.Errorf("can not close temp file %v", oldname)
+	}
 	if err := os.Rename(oldname, newname); err != nil {
-		fmt.Fprintf(os.Stderr, "%s Worker: reduce can not rename temp file %v\n", time.Now().String(), oldname)
-		return false
+		return fmt.Errorf("can not rename temp file %v", oldname)
 	}
 
-	return true
+	return nil
 }
 
 /*
@@ -160,14 +266,19 @@ func executeReduce(reducef func(string, []string) string, split int, index int)
 	if coordinator crashes we assume that we are done.
 */
 func Worker(mapf func(string, string) []KeyValue,
-	reducef func(string, []string) string) {
+	reducef func(string, []string) string, opts ...WorkerOption) {
+
+	cfg := workerConfig{network: "unix", address: coordinatorSock()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	for {
 		args := QueryArgs{}
 		reply := QueryReply{}
 		// can not connect to the coordinator
 		// assume that the coordinator has exited, then exit
-		if !(call("Coordinator.HandleQuery", &args, &reply)) {
+		if !(call(cfg.network, cfg.address, "Coordinator.HandleQuery", &args, &reply)) {
 			fmt.Fprintf(os.Stderr, "%s Worker: exit", time.Now().String())
 			os.Exit(0)
 		}
@@ -181,9 +292,9 @@ func Worker(mapf func(string, string) []KeyValue,
 		responseArgs.Kind = reply.Kind
 		responseArgs.Index = reply.Index
 		if reply.Kind == "map" {
-			if executeMap(mapf, reply.File, reply.NReduce, reply.Index) {
+			if executeMap(mapf, cfg.streamf, reply.File, reply.NReduce, reply.Index) {
 				fmt.Fprintf(os.Stderr, "%s Worker: map task performed successfully\n", time.Now().String())
-				if !(call("Coordinator.HandleResponse", &responseArgs, &responseReply)) {
+				if !(call(cfg.network, cfg.address, "Coordinator.HandleResponse", &responseArgs, &responseReply)) {
 					fmt.Fprintf(os.Stderr, "%s Worker: exit", time.Now().String())
 					os.Exit(0)
 				}
@@ -191,12 +302,16 @@ func Worker(mapf func(string, string) []KeyValue,
 				fmt.Fprintf(os.Stderr, "%s Worker: map task failed\n", time.Now().String())
 			}
 		} else {
-			if executeReduce(reducef, reply.Split, reply.Index) {
+			if err := executeReduce(reducef, cfg.verifier, reply.Split, reply.Index); err == nil {
 				fmt.Fprintf(os.Stderr, "%s Worker: reduce task performed successfully\n", time.Now().String())
-				if !(call("Coordinator.HandleResponse", &responseArgs, &responseReply)) {
-					fmt.Fprintf(os.Stderr, "%s Worker: exit", time.Now().String())
-					os.Exit(0)
-				}
+			} else {
+				fmt.Fprintf(os.Stderr, "%s Worker: reduce task failed: %v\n", time.Now().String(), err)
+				// report the failure so the coordinator can count it against the task
+				responseArgs.Err = err.Error()
+			}
+			if !(call(cfg.network, cfg.address, "Coordinator.HandleResponse", &responseArgs, &responseReply)) {
+				fmt.Fprintf(os.Stderr, "%s Worker: exit", time.Now().String())
+				os.Exit(0)
 			}
 		}
 
@@ -209,10 +324,8 @@ func Worker(mapf func(string, string) []KeyValue,
 // usually returns true.
 // returns false if something goes wrong.
 //
-func call(rpcname string, args interface{}, reply interface{}) bool {
-	// c, err := rpc.DialHTTP("tcp", "127.0.0.1"+":1234")
-	sockname := coordinatorSock()
-	c, err := rpc.DialHTTP("unix", sockname)
+func call(network string, address string, rpcname string, args interface{}, reply interface{}) bool {
+	c, err := rpc.DialHTTP(network, address)
 	if err != nil {
 		log.Fatal("dialing:", err)
 	}
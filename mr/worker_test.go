@@ -0,0 +1,191 @@
+package mr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run the test inside a fresh temporary directory, since tasks
+// read and write their files in the current directory.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func writeInput(t *testing.T, name string, contents string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countReduce(key string, values []string) string {
+	return fmt.Sprint(len(values))
+}
+
+func TestEmitToAllReachesEveryPartition(t *testing.T) {
+	inTempDir(t)
+	writeInput(t, "input.txt", "a b c d e f")
+
+	nReduce := 3
+	streamf := func(filename string, contents string, e *Emitter) {
+		for _, w := range strings.Fields(contents) {
+			e.Emit(w, "1")
+		}
+		e.EmitToAll("broadcast", "1")
+	}
+	if !executeMap(nil, streamf, "input.txt", nReduce, 0) {
+		t.Fatal("map task failed")
+	}
+
+	for i := 0; i < nReduce; i++ {
+		if executeReduce(countReduce, nil, 1, i) != nil {
+			t.Fatalf("reduce task %d failed", i)
+		}
+		out, err := os.ReadFile(fmt.Sprintf("mr-out-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), "broadcast 1\n") {
+			t.Fatalf("mr-out-%d missing broadcast record:\n%s", i, out)
+		}
+	}
+}
+
+func TestEmitToPartitionOutOfRange(t *testing.T) {
+	e := newEmitter(2)
+	if err := e.EmitToPartition(1, "k", "v"); err != nil {
+		t.Fatalf("EmitToPartition(1) = %v, want nil", err)
+	}
+	for _, p := range []int{-1, 2} {
+		if err := e.EmitToPartition(p, "k", "v"); err == nil {
+			t.Fatalf("EmitToPartition(%d) = nil, want error", p)
+		}
+	}
+	if len(e.partitions[1]) != 1 {
+		t.Fatalf("partition 1 has %d records, want 1", len(e.partitions[1]))
+	}
+}
+
+func wordsMap(filename string, contents string) []KeyValue {
+	kva := []KeyValue{}
+	for _, w := range strings.Fields(contents) {
+		kva = append(kva, KeyValue{w, "1"})
+	}
+	return kva
+}
+
+// not a real test: run by TestConcurrentProcessesSameTask in a child
+// process, executing map task 0 and every reduce task in MR_TEST_DIR.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("MR_TEST_HELPER") != "1" {
+		return
+	}
+	if err := os.Chdir(os.Getenv("MR_TEST_DIR")); err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(procToken)
+	if !executeMap(wordsMap, nil, "input.txt", 4, 0) {
+		os.Exit(1)
+	}
+	for i := 0; i < 4; i++ {
+		if executeReduce(countReduce, nil, 1, i) != nil {
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestConcurrentProcessesSameTask(t *testing.T) {
+	dir := inTempDir(t)
+	words := []string{}
+	for i := 0; i < 20000; i++ {
+		words = append(words, fmt.Sprintf("w%d", i%100))
+	}
+	writeInput(t, "input.txt", strings.Join(words, " "))
+
+	cmds := make([]*exec.Cmd, 2)
+	outs := make([]strings.Builder, 2)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+		cmds[i].Env = append(os.Environ(), "MR_TEST_HELPER=1", "MR_TEST_DIR="+dir)
+		cmds[i].Stdout = &outs[i]
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("process %d failed: %v", i, err)
+		}
+	}
+
+	token0 := strings.TrimSpace(outs[0].String())
+	token1 := strings.TrimSpace(outs[1].String())
+	if token0 == "" || token0 == token1 {
+		t.Fatalf("processes share temp token %q and %q", token0, token1)
+	}
+
+	temps, _ := filepath.Glob("temp*")
+	if len(temps) != 0 {
+		t.Fatalf("temp files left behind: %v", temps)
+	}
+
+	// every intermediate file must be one complete, uncorrupted copy
+	total := 0
+	for i := 0; i < 4; i++ {
+		file, err := os.Open(fmt.Sprintf("inter_0_%d.json", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(file)
+		for {
+			var kv KeyValue
+			if err := dec.Decode(&kv); err != nil {
+				break
+			}
+			if ihash(kv.Key)%4 != i {
+				t.Fatalf("key %v in wrong partition %d", kv.Key, i)
+			}
+			total++
+		}
+		file.Close()
+	}
+	if total != len(words) {
+		t.Fatalf("intermediate files hold %d records, want %d", total, len(words))
+	}
+
+	seen := 0
+	for i := 0; i < 4; i++ {
+		out, err := os.ReadFile(fmt.Sprintf("mr-out-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if line == "" {
+				continue
+			}
+			if !strings.HasSuffix(line, " 200") {
+				t.Fatalf("mr-out-%d: bad record %q", i, line)
+			}
+			seen++
+		}
+	}
+	if seen != 100 {
+		t.Fatalf("mr-out-* hold %d keys, want 100", seen)
+	}
+}